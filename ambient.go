@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DataPlaneMode selects which Istio data plane semantics the restarter understands.
+type DataPlaneMode string
+
+const (
+	ModeSidecar DataPlaneMode = "sidecar"
+	ModeAmbient DataPlaneMode = "ambient"
+	ModeAuto    DataPlaneMode = "auto"
+)
+
+// ambientDataplaneLabel marks a namespace or pod as participating in the Istio ambient mesh.
+const ambientDataplaneLabel = "istio.io/dataplane-mode"
+
+// ambientWorkloads defines the ambient-specific control plane components, restarted in addition
+// to istioWorkloads when running in ambient or auto mode.
+var ambientWorkloads = []IstioWorkload{
+	{Name: "ztunnel", Type: DaemonSetType},
+}
+
+// gatewayGVR identifies the Gateway API resource used to discover waypoint proxies.
+var gatewayGVR = schema.GroupVersionResource{
+	Group:    "gateway.networking.k8s.io",
+	Version:  "v1",
+	Resource: "gateways",
+}
+
+// workloadsForMode returns the Istio control-plane workloads to restart for the given data plane mode.
+func workloadsForMode(mode DataPlaneMode) []IstioWorkload {
+	if ambientEligible(mode) {
+		return append(append([]IstioWorkload{}, istioWorkloads...), ambientWorkloads...)
+	}
+	return istioWorkloads
+}
+
+// ambientEligible reports whether mode considers ambient-enrolled pods (ztunnel/waypoint) at all.
+func ambientEligible(mode DataPlaneMode) bool {
+	return mode == ModeAmbient || mode == ModeAuto
+}
+
+// sidecarEligible reports whether mode restarts the owning workload of classic sidecar-injected
+// pods. Pure ambient mode restricts to ambient data plane semantics, so it does not.
+func sidecarEligible(mode DataPlaneMode) bool {
+	return mode == ModeSidecar || mode == ModeAuto
+}
+
+// isAmbientNamespace reports whether a namespace opts into ambient mode via its dataplane-mode label.
+func isAmbientNamespace(ns *corev1.Namespace) bool {
+	return ns.Labels[ambientDataplaneLabel] == "ambient"
+}
+
+// isAmbientPod reports whether a pod is enrolled in the ambient mesh, either directly via its own
+// label or by inheriting its namespace's default.
+func isAmbientPod(pod *corev1.Pod, namespaceIsAmbient bool) bool {
+	if mode, ok := pod.Labels[ambientDataplaneLabel]; ok {
+		return mode == "ambient"
+	}
+	return namespaceIsAmbient
+}
+
+// isWaypointProxy reports whether a Deployment is a waypoint proxy, identified by the labels
+// Istio's waypoint controller stamps on the Deployments it manages.
+func isWaypointProxy(dep *appsv1.Deployment) bool {
+	if _, ok := dep.Labels["istio.io/waypoint-for"]; ok {
+		return true
+	}
+	_, ok := dep.Labels["gateway.networking.k8s.io/gateway-name"]
+	return ok
+}
+
+// listWaypointProxies discovers waypoint-proxy Deployments in a namespace by listing Gateways that
+// carry the waypoint-for label, then resolving each Gateway to the Deployment of the same name that
+// Istio's waypoint controller creates for it.
+func listWaypointProxies(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, namespace string) ([]appsv1.Deployment, error) {
+	gws, err := dyn.Resource(gatewayGVR).Namespace(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "istio.io/waypoint-for",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Gateways in namespace %s: %v", namespace, err)
+	}
+
+	var waypoints []appsv1.Deployment
+	for _, gw := range gws.Items {
+		dep, err := clientset.AppsV1().Deployments(namespace).Get(ctx, gw.GetName(), metav1.GetOptions{})
+		if err != nil {
+			log.Printf("Skipping waypoint for Gateway %s/%s: %v", namespace, gw.GetName(), err)
+			continue
+		}
+		if isWaypointProxy(dep) {
+			waypoints = append(waypoints, *dep)
+		}
+	}
+	return waypoints, nil
+}
+
+// restartZtunnelOnNode restarts the ztunnel pod running on a given node by deleting it outright,
+// since ztunnel is a DaemonSet member with no sidecar to annotate: the kubelet re-admits a fresh
+// pod as soon as the old one is gone.
+func restartZtunnelOnNode(ctx context.Context, clientset *kubernetes.Clientset, nodeName string) error {
+	pods, err := clientset.CoreV1().Pods(istioNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app=ztunnel",
+		FieldSelector: fmt.Sprintf("spec.nodeName=%s", nodeName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list ztunnel pods on node %s: %v", nodeName, err)
+	}
+
+	for _, pod := range pods.Items {
+		if err := clientset.CoreV1().Pods(istioNamespace).Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("failed to delete ztunnel pod %s: %v", pod.Name, err)
+		}
+		log.Printf("Deleted ztunnel pod %s on node %s to pick up a fresh restart", pod.Name, nodeName)
+	}
+	return nil
+}
+
+// processAmbientPod restarts the ztunnel instance on the pod's node and any waypoint proxy
+// fronting its namespace, going through coordinator so both get the same PDB-aware throttling and
+// exclude/skip checks as every other restart. seen, if non-nil, dedupes ztunnel-per-node and
+// waypoint-per-Deployment restarts across repeated calls within a single pass, the same "namespace/
+// kind/name" keys planAmbientPod uses for the dry-run plan.
+func processAmbientPod(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, coordinator *RolloutCoordinator, pod *corev1.Pod, seen map[string]bool) error {
+	ztunnelKey := workloadKey(istioNamespace, "Pod", "ztunnel-on-"+pod.Spec.NodeName)
+	if seen == nil || !seen[ztunnelKey] {
+		if seen != nil {
+			seen[ztunnelKey] = true
+		}
+		if err := coordinator.restartZtunnelOnNodeSafely(ctx, clientset, dyn, pod.Spec.NodeName); err != nil {
+			log.Printf("Failed to restart ztunnel for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+
+	return restartWaypointsInNamespace(ctx, clientset, dyn, coordinator, pod.Namespace, seen)
+}
+
+// restartWaypointsInNamespace discovers and restarts every waypoint proxy fronting namespace,
+// routing each through coordinator.restartWorkloadSafely since a waypoint is just a Deployment.
+// seen, if non-nil, skips any waypoint already restarted during this pass.
+func restartWaypointsInNamespace(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, coordinator *RolloutCoordinator, namespace string, seen map[string]bool) error {
+	waypoints, err := listWaypointProxies(ctx, clientset, dyn, namespace)
+	if err != nil {
+		log.Printf("Failed to list waypoint proxies in namespace %s: %v", namespace, err)
+		return nil
+	}
+	for _, wp := range waypoints {
+		key := workloadKey(wp.Namespace, "Deployment", wp.Name)
+		if seen != nil {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		if err := coordinator.restartWorkloadSafely(ctx, clientset, dyn, wp.Namespace, wp.Name, "Deployment", ""); err != nil {
+			log.Printf("Failed to restart waypoint %s/%s: %v", wp.Namespace, wp.Name, err)
+		}
+	}
+	return nil
+}
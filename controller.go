@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Controller watches pods via shared informers and queues a sidecar restart for each owning
+// workload whenever one of its pods needs a fresh Istio sidecar. Repeated triggers from the same
+// workload - e.g. 100 pods belonging to one Deployment - collapse into a single queued rollout,
+// since workqueue.RateLimitingInterface dedupes by key while an item is pending or being processed.
+type Controller struct {
+	clientset         *kubernetes.Clientset
+	dyn               dynamic.Interface
+	queue             workqueue.RateLimitingInterface
+	mode              DataPlaneMode
+	strategy          RestartStrategy
+	coordinator       *RolloutCoordinator
+	includeNamespaces []string
+	excludeNamespaces []string
+}
+
+// workloadKey identifies a workload to restart as "namespace/kind/name", e.g. "default/Deployment/web".
+func workloadKey(namespace, kind, name string) string {
+	return fmt.Sprintf("%s/%s/%s", namespace, kind, name)
+}
+
+func splitWorkloadKey(key string) (namespace, kind, name string, err error) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("invalid workload key %q", key)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// NewController builds a Controller wired to the Pods informer of podFactory and the workload
+// informers of workloadFactory, backed by a rate-limited work queue keyed by owning workload.
+// These are two separate factories, rather than one shared factory, because only the Pods
+// informer should be scoped by -label-selector/-field-selector; workloadFactory watches every
+// Deployment/DaemonSet/StatefulSet regardless of those pod-level filters.
+func NewController(clientset *kubernetes.Clientset, dyn dynamic.Interface, podFactory, workloadFactory informers.SharedInformerFactory, mode DataPlaneMode, strategy RestartStrategy, coordinator *RolloutCoordinator, includeNamespaces, excludeNamespaces []string) *Controller {
+	c := &Controller{
+		clientset:         clientset,
+		dyn:               dyn,
+		queue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		mode:              mode,
+		strategy:          strategy,
+		coordinator:       coordinator,
+		includeNamespaces: includeNamespaces,
+		excludeNamespaces: excludeNamespaces,
+	}
+
+	podFactory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.handlePod,
+		UpdateFunc: func(_, newObj interface{}) { c.handlePod(newObj) },
+	})
+
+	// Also watch the workload kinds directly, so a Deployment/DaemonSet/StatefulSet update (e.g.
+	// a new istiod proxy version rolling out) is noticed even before its pods churn.
+	workloadFactory.Apps().V1().Deployments().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.handleDeployment,
+		UpdateFunc: func(_, newObj interface{}) { c.handleDeployment(newObj) },
+	})
+	workloadFactory.Apps().V1().DaemonSets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.handleDaemonSet,
+		UpdateFunc: func(_, newObj interface{}) { c.handleDaemonSet(newObj) },
+	})
+	workloadFactory.Apps().V1().StatefulSets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.handleStatefulSet,
+		UpdateFunc: func(_, newObj interface{}) { c.handleStatefulSet(newObj) },
+	})
+
+	return c
+}
+
+// handlePod resolves a pod to its owning workload and enqueues a restart if the pod carries an
+// Istio sidecar or is enrolled in the ambient mesh. Ambient pods are queued as ZtunnelNode and
+// AmbientWaypoints keys rather than resolved to an owning workload, the same distinction
+// processPod draws in the batch path.
+func (c *Controller) handlePod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	if !namespaceIncluded(pod.Namespace, c.includeNamespaces, c.excludeNamespaces) {
+		return
+	}
+
+	ctx := context.Background()
+	ambient := false
+	if ambientEligible(c.mode) {
+		ns, err := c.clientset.CoreV1().Namespaces().Get(ctx, pod.Namespace, metav1.GetOptions{})
+		if err == nil {
+			ambient = isAmbientPod(pod, isAmbientNamespace(ns))
+		}
+	}
+	if !ambient && (!sidecarEligible(c.mode) || !hasIstioSidecar(pod)) {
+		return
+	}
+
+	if ambient {
+		c.queue.Add(workloadKey(istioNamespace, "ZtunnelNode", pod.Spec.NodeName))
+		c.queue.Add(workloadKey(pod.Namespace, "AmbientWaypoints", pod.Namespace))
+		return
+	}
+
+	if len(pod.OwnerReferences) == 0 {
+		c.queue.Add(workloadKey(pod.Namespace, "Pod", pod.Name))
+		return
+	}
+
+	key, err := resolveOwnerKey(ctx, c.clientset, pod.Namespace, pod.OwnerReferences[0])
+	if err != nil {
+		log.Printf("Failed to resolve owner for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// handleDeployment enqueues a restart when a Deployment carrying an Istio sidecar in its pod
+// template is created or updated.
+func (c *Controller) handleDeployment(obj interface{}) {
+	dep, ok := obj.(*appsv1.Deployment)
+	if !ok {
+		return
+	}
+	c.handleWorkload("Deployment", dep.Namespace, dep.Name, dep.Spec.Template.Spec.InitContainers)
+}
+
+// handleDaemonSet enqueues a restart when a DaemonSet carrying an Istio sidecar in its pod
+// template is created or updated.
+func (c *Controller) handleDaemonSet(obj interface{}) {
+	ds, ok := obj.(*appsv1.DaemonSet)
+	if !ok {
+		return
+	}
+	c.handleWorkload("DaemonSet", ds.Namespace, ds.Name, ds.Spec.Template.Spec.InitContainers)
+}
+
+// handleStatefulSet enqueues a restart when a StatefulSet carrying an Istio sidecar in its pod
+// template is created or updated.
+func (c *Controller) handleStatefulSet(obj interface{}) {
+	ss, ok := obj.(*appsv1.StatefulSet)
+	if !ok {
+		return
+	}
+	c.handleWorkload("StatefulSet", ss.Namespace, ss.Name, ss.Spec.Template.Spec.InitContainers)
+}
+
+// handleWorkload enqueues a restart for a workload whose pod template carries an Istio sidecar, so
+// a control-plane change to the workload itself (e.g. a new istiod proxy version) is noticed even
+// before any of its pods churn. Workloads without a sidecar in their template, and anything outside
+// -mode sidecar/auto, are left to handlePod's per-pod checks.
+func (c *Controller) handleWorkload(kind, namespace, name string, initContainers []corev1.Container) {
+	if !sidecarEligible(c.mode) || !hasIstioSidecarInitContainers(initContainers) {
+		return
+	}
+	if !namespaceIncluded(namespace, c.includeNamespaces, c.excludeNamespaces) {
+		return
+	}
+	c.queue.Add(workloadKey(namespace, kind, name))
+}
+
+// resolveOwnerKey walks the owner chain the same way traverseOwners does, but returns the workload
+// key to enqueue instead of restarting it immediately - the actual restart happens later, once,
+// when the key is dequeued by a worker.
+func resolveOwnerKey(ctx context.Context, clientset *kubernetes.Clientset, namespace string, ownerRef metav1.OwnerReference) (string, error) {
+	if ownerRef.Kind == "ReplicaSet" {
+		rs, err := clientset.AppsV1().ReplicaSets(namespace).Get(ctx, ownerRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get ReplicaSet %s: %v", ownerRef.Name, err)
+		}
+		if len(rs.OwnerReferences) > 0 {
+			return resolveOwnerKey(ctx, clientset, namespace, rs.OwnerReferences[0])
+		}
+		return workloadKey(namespace, "ReplicaSet", ownerRef.Name), nil
+	}
+	return workloadKey(namespace, ownerRef.Kind, ownerRef.Name), nil
+}
+
+// Run starts the given number of worker goroutines and blocks until stopCh is closed.
+func (c *Controller) Run(workers int, stopCh <-chan struct{}) {
+	defer c.queue.ShutDown()
+
+	log.Printf("Starting %d restart controller workers", workers)
+	for i := 0; i < workers; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+
+	<-stopCh
+	log.Println("Stopping restart controller")
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+// processNextWorkItem restarts the workload named by the next queued key, retrying with
+// exponential backoff (workqueue.DefaultControllerRateLimiter) on failure - the common case being
+// an Update conflict from a concurrent change to the same object.
+func (c *Controller) processNextWorkItem() bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.restartForKey(key.(string)); err != nil {
+		log.Printf("Error restarting %s, retrying: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+// restartForKey performs the actual restart for a queued workload key.
+func (c *Controller) restartForKey(key string) error {
+	namespace, kind, name, err := splitWorkloadKey(key)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch kind {
+	case "Deployment", "DaemonSet", "StatefulSet", "ReplicationController", "DeploymentConfig":
+		return c.coordinator.restartWorkloadSafely(ctx, c.clientset, c.dyn, namespace, name, kind, "")
+	case "ZtunnelNode":
+		return c.coordinator.restartZtunnelOnNodeSafely(ctx, c.clientset, c.dyn, name)
+	case "AmbientWaypoints":
+		return restartWaypointsInNamespace(ctx, c.clientset, c.dyn, c.coordinator, namespace, nil)
+	case "Job":
+		if c.strategy == StrategyAnnotate {
+			log.Printf("Skipping Job %s/%s: Jobs have no rolling-update path and -strategy=annotate", namespace, name)
+			return nil
+		}
+		return restartJob(ctx, c.clientset, namespace, name)
+	case "Pod":
+		if c.strategy == StrategyAnnotate {
+			log.Printf("Skipping pod %s/%s: no owner references and -strategy=annotate", namespace, name)
+			return nil
+		}
+		return deletePodForRestart(ctx, c.clientset, namespace, name)
+	default:
+		log.Printf("Unsupported owner kind in queue: %s for %s/%s", kind, namespace, name)
+		return nil
+	}
+}
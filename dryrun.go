@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// OutputFormat controls how a dry-run plan is rendered.
+type OutputFormat string
+
+const (
+	OutputText OutputFormat = "text"
+	OutputJSON OutputFormat = "json"
+	OutputYAML OutputFormat = "yaml"
+)
+
+// RestartAction describes a single restart that either already happened or, in dry-run mode,
+// would happen: which workload, why it was triggered, and what sidecar version change it carries.
+// restartIstioWorkload, restartWorkload, and processPod all correspond to one RestartAction each;
+// dry-run builds the same set of actions they would produce, without calling Update.
+type RestartAction struct {
+	Order          int    `json:"order"`
+	Namespace      string `json:"namespace"`
+	Kind           string `json:"kind"`
+	Name           string `json:"name"`
+	Reason         string `json:"reason"`
+	CurrentVersion string `json:"currentVersion,omitempty"`
+	TargetVersion  string `json:"targetVersion,omitempty"`
+}
+
+// planDryRun walks the same discovery logic the real run uses - Istio control-plane workloads,
+// then per-namespace pods with sidecars or ambient enrollment, then owner traversal - but builds a
+// RestartAction for each workload instead of restarting it. It applies the same -label-selector,
+// -field-selector, -exclude-workload, and skip-annotation filters the real run would, and reflects
+// -strategy's effect on bare pods, so the plan it prints matches what that run would actually do.
+func planDryRun(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, namespaces []string, mode DataPlaneMode, strategy RestartStrategy, excludeWorkloads []string, labelSelector, fieldSelector string) ([]RestartAction, error) {
+	var actions []RestartAction
+
+	istiodVersion, err := istiodImageTag(ctx, clientset)
+	if err != nil {
+		istiodVersion = ""
+	}
+
+	for _, workload := range workloadsForMode(mode) {
+		actions = append(actions, buildIstioWorkloadAction(ctx, clientset, workload))
+	}
+
+	seen := make(map[string]bool)
+	for _, ns := range namespaces {
+		namespaceIsAmbient := false
+		if ambientEligible(mode) {
+			nsObj, err := clientset.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get namespace %s: %v", ns, err)
+			}
+			namespaceIsAmbient = isAmbientNamespace(nsObj)
+		}
+
+		pods, err := clientset.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{
+			LabelSelector: labelSelector,
+			FieldSelector: fieldSelector,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods in namespace %s: %v", ns, err)
+		}
+
+		for _, pod := range pods.Items {
+			ambient := ambientEligible(mode) && isAmbientPod(&pod, namespaceIsAmbient)
+			if !ambient && (!sidecarEligible(mode) || !hasIstioSidecar(&pod)) {
+				continue
+			}
+
+			if ambient {
+				for _, a := range planAmbientPod(ctx, clientset, dyn, &pod, seen) {
+					// The ztunnel entry is checked as the ztunnel DaemonSet, as restartZtunnelOnNodeSafely does.
+					kind, name := a.Kind, a.Name
+					if kind == "ztunnel" {
+						kind, name = "DaemonSet", "ztunnel"
+					}
+					if workloadExcluded(a.Namespace, kind, name, excludeWorkloads) {
+						continue
+					}
+					if skipped, err := isWorkloadSkipped(ctx, clientset, dyn, a.Namespace, name, kind); err != nil || skipped {
+						continue
+					}
+					actions = append(actions, a)
+				}
+				continue
+			}
+
+			if len(pod.OwnerReferences) == 0 {
+				if strategy == StrategyAnnotate {
+					continue // matches processPod: no owner references and -strategy=annotate
+				}
+				podKey := workloadKey(ns, "Pod", pod.Name)
+				if seen[podKey] {
+					continue
+				}
+				seen[podKey] = true
+				actions = append(actions, RestartAction{
+					Namespace: ns,
+					Kind:      "Pod",
+					Name:      pod.Name,
+					Reason:    fmt.Sprintf("bare pod %s/%s carries an Istio sidecar and has no owner to roll", ns, pod.Name),
+				})
+				continue
+			}
+
+			key, err := resolveOwnerKey(ctx, clientset, ns, pod.OwnerReferences[0])
+			if err != nil {
+				log.Printf("Failed to resolve owner for pod %s/%s: %v", ns, pod.Name, err)
+				continue
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			namespace, kind, name, err := splitWorkloadKey(key)
+			if err != nil {
+				continue
+			}
+			if kind == "Job" && strategy == StrategyAnnotate {
+				continue // matches traverseOwners: Jobs are skipped under -strategy=annotate
+			}
+			if kind != "Job" && !isSafeRestartKind(kind) {
+				continue // matches traverseOwners: other owner kinds are only logged, not restarted
+			}
+			if workloadExcluded(namespace, kind, name, excludeWorkloads) {
+				continue
+			}
+			skipped, err := isWorkloadSkipped(ctx, clientset, dyn, namespace, name, kind)
+			if err != nil {
+				log.Printf("Failed to check skip annotation for %s %s/%s: %v", kind, namespace, name, err)
+				continue
+			}
+			if skipped {
+				continue
+			}
+
+			currentVersion, _ := proxyImageTag(&pod)
+			actions = append(actions, RestartAction{
+				Namespace:      namespace,
+				Kind:           kind,
+				Name:           name,
+				Reason:         fmt.Sprintf("pod %s/%s carries an Istio sidecar", ns, pod.Name),
+				CurrentVersion: currentVersion,
+				TargetVersion:  istiodVersion,
+			})
+		}
+	}
+
+	for i := range actions {
+		actions[i].Order = i
+	}
+	return actions, nil
+}
+
+// planAmbientPod mirrors processAmbientPod: rolling the ztunnel instance on the pod's node and any
+// waypoint proxy fronting its namespace, recorded as RestartActions instead of being applied. seen
+// dedupes by the same "namespace/kind/name" key used elsewhere, keyed per node for ztunnel and per
+// Deployment for waypoints.
+func planAmbientPod(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, pod *corev1.Pod, seen map[string]bool) []RestartAction {
+	var actions []RestartAction
+
+	ztunnelKey := workloadKey(istioNamespace, "Pod", "ztunnel-on-"+pod.Spec.NodeName)
+	if !seen[ztunnelKey] {
+		seen[ztunnelKey] = true
+		actions = append(actions, RestartAction{
+			Namespace: istioNamespace,
+			Kind:      "ztunnel",
+			Name:      pod.Spec.NodeName,
+			Reason:    fmt.Sprintf("ambient pod %s/%s runs on this node", pod.Namespace, pod.Name),
+		})
+	}
+
+	waypoints, err := listWaypointProxies(ctx, clientset, dyn, pod.Namespace)
+	if err != nil {
+		log.Printf("Failed to list waypoint proxies in namespace %s: %v", pod.Namespace, err)
+		return actions
+	}
+	for _, wp := range waypoints {
+		key := workloadKey(wp.Namespace, "Deployment", wp.Name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		actions = append(actions, RestartAction{
+			Namespace: wp.Namespace,
+			Kind:      "Deployment",
+			Name:      wp.Name,
+			Reason:    fmt.Sprintf("waypoint proxy fronting ambient pod %s/%s", pod.Namespace, pod.Name),
+		})
+	}
+
+	return actions
+}
+
+func buildIstioWorkloadAction(ctx context.Context, clientset *kubernetes.Clientset, workload IstioWorkload) RestartAction {
+	action := RestartAction{
+		Namespace: istioNamespace,
+		Name:      workload.Name,
+		Reason:    "Istio control-plane component",
+	}
+
+	switch workload.Type {
+	case DeploymentType:
+		action.Kind = "Deployment"
+		if dep, err := clientset.AppsV1().Deployments(istioNamespace).Get(ctx, workload.Name, metav1.GetOptions{}); err == nil {
+			for _, c := range dep.Spec.Template.Spec.Containers {
+				action.CurrentVersion = imageTag(c.Image)
+				break
+			}
+		}
+	case DaemonSetType:
+		action.Kind = "DaemonSet"
+		if ds, err := clientset.AppsV1().DaemonSets(istioNamespace).Get(ctx, workload.Name, metav1.GetOptions{}); err == nil {
+			for _, c := range ds.Spec.Template.Spec.Containers {
+				action.CurrentVersion = imageTag(c.Image)
+				break
+			}
+		}
+	}
+
+	return action
+}
+
+// renderPlan formats a dry-run plan for output, in the order the actions were discovered.
+func renderPlan(actions []RestartAction, output OutputFormat) (string, error) {
+	switch output {
+	case OutputJSON:
+		b, err := json.MarshalIndent(actions, "", "  ")
+		return string(b), err
+	case OutputYAML:
+		b, err := yaml.Marshal(actions)
+		return string(b), err
+	default:
+		var sb strings.Builder
+		for _, a := range actions {
+			fmt.Fprintf(&sb, "%d. %s %s/%s", a.Order+1, a.Kind, a.Namespace, a.Name)
+			if a.CurrentVersion != "" || a.TargetVersion != "" {
+				fmt.Fprintf(&sb, " (%s -> %s)", a.CurrentVersion, a.TargetVersion)
+			}
+			fmt.Fprintf(&sb, ": %s\n", a.Reason)
+		}
+		return sb.String(), nil
+	}
+}
+
+// runDryRun prints the restart plan for the given namespaces and mode without restarting anything.
+func runDryRun(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, namespaces []string, mode DataPlaneMode, strategy RestartStrategy, excludeWorkloads []string, labelSelector, fieldSelector string, output OutputFormat) error {
+	actions, err := planDryRun(ctx, clientset, dyn, namespaces, mode, strategy, excludeWorkloads, labelSelector, fieldSelector)
+	if err != nil {
+		return err
+	}
+
+	rendered, err := renderPlan(actions, output)
+	if err != nil {
+		return fmt.Errorf("failed to render plan: %v", err)
+	}
+
+	fmt.Print(rendered)
+	return nil
+}
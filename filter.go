@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// skipAnnotation lets an individual workload opt out of restarts even when it matches the
+// selectors and namespace filters the restarter was invoked with.
+const skipAnnotation = "istio-restarter.io/skip"
+
+// parseCSV splits a comma-separated flag value into a trimmed, non-empty slice.
+func parseCSV(value string) []string {
+	if value == "" {
+		return nil
+	}
+	var out []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// namespaceIncluded reports whether a namespace should be processed, given an optional allow-list
+// (include) and deny-list (exclude). An empty include list means all namespaces are eligible.
+func namespaceIncluded(namespace string, include, exclude []string) bool {
+	for _, ns := range exclude {
+		if ns == namespace {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, ns := range include {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// workloadExcluded reports whether a workload matches one of the -exclude-workload entries, which
+// may be a full "namespace/kind/name" key or a bare "kind/name" applied across every namespace.
+func workloadExcluded(namespace, kind, name string, exclude []string) bool {
+	full := workloadKey(namespace, kind, name)
+	short := fmt.Sprintf("%s/%s", kind, name)
+	for _, entry := range exclude {
+		if entry == full || entry == short {
+			return true
+		}
+	}
+	return false
+}
+
+// isWorkloadSkipped checks the istio-restarter.io/skip annotation on the named workload.
+func isWorkloadSkipped(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, namespace, name, kind string) (bool, error) {
+	var annotations map[string]string
+
+	switch kind {
+	case "Deployment":
+		obj, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get Deployment %s: %v", name, err)
+		}
+		annotations = obj.Annotations
+	case "DaemonSet":
+		obj, err := clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get DaemonSet %s: %v", name, err)
+		}
+		annotations = obj.Annotations
+	case "StatefulSet":
+		obj, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get StatefulSet %s: %v", name, err)
+		}
+		annotations = obj.Annotations
+	case "ReplicationController":
+		obj, err := clientset.CoreV1().ReplicationControllers(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get ReplicationController %s: %v", name, err)
+		}
+		annotations = obj.Annotations
+	case "DeploymentConfig":
+		obj, err := dyn.Resource(deploymentConfigGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get DeploymentConfig %s: %v", name, err)
+		}
+		annotations = obj.GetAnnotations()
+	default:
+		return false, nil
+	}
+
+	return annotations[skipAnnotation] == "true", nil
+}
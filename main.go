@@ -5,10 +5,15 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -40,6 +45,22 @@ func main() {
 	// Parse command line flags
 	namespace := flag.String("namespace", "", "namespace to search pods in")
 	allNamespaces := flag.Bool("all-namespaces", false, "search pods in all namespaces")
+	modeFlag := flag.String("mode", string(ModeSidecar), "data plane mode to restart: sidecar, ambient, or auto")
+	strategyFlag := flag.String("strategy", string(StrategyAuto), "restart strategy for workloads with no rolling-update path: annotate, delete, or auto")
+	once := flag.Bool("once", false, "run a single batch pass and exit, instead of watching continuously")
+	workers := flag.Int("workers", 2, "number of restart worker goroutines when running continuously")
+	maxConcurrentRestarts := flag.Int("max-concurrent-restarts", 5, "maximum number of workloads restarted at once across the cluster")
+	perNamespaceConcurrency := flag.Int("per-namespace-concurrency", 1, "maximum number of workloads restarted at once within a single namespace")
+	restartInterval := flag.Duration("restart-interval", 10*time.Second, "delay between restarting each control-plane component (istiod, gateway, CNI, then workloads)")
+	labelSelector := flag.String("label-selector", "", "label selector to filter which pods are considered for restart")
+	fieldSelector := flag.String("field-selector", "", "field selector to filter which pods are considered for restart")
+	includeNamespaceFlag := flag.String("include-namespace", "", "comma-separated list of namespaces to restrict restarts to (default: all eligible namespaces)")
+	excludeNamespaceFlag := flag.String("exclude-namespace", "", "comma-separated list of namespaces to never restart workloads in")
+	excludeWorkloadFlag := flag.String("exclude-workload", "", "comma-separated list of namespace/kind/name or kind/name workloads to never restart")
+	preflight := flag.Bool("preflight", false, "run preflight compatibility checks before restarting anything, and abort if any hard check fails")
+	preflightOnly := flag.Bool("preflight-only", false, "run preflight compatibility checks and exit without restarting anything")
+	dryRun := flag.Bool("dry-run", false, "print the restart plan without restarting anything")
+	outputFlag := flag.String("output", string(OutputText), "dry-run plan format: text, json, or yaml")
 	flag.Parse()
 
 	// Validate namespace configuration
@@ -47,6 +68,27 @@ func main() {
 		log.Fatalf("Must specify either -namespace or -all-namespaces")
 	}
 
+	mode := DataPlaneMode(*modeFlag)
+	switch mode {
+	case ModeSidecar, ModeAmbient, ModeAuto:
+	default:
+		log.Fatalf("Invalid -mode %q: must be one of sidecar, ambient, auto", *modeFlag)
+	}
+
+	strategy := RestartStrategy(*strategyFlag)
+	switch strategy {
+	case StrategyAnnotate, StrategyDelete, StrategyAuto:
+	default:
+		log.Fatalf("Invalid -strategy %q: must be one of annotate, delete, auto", *strategyFlag)
+	}
+
+	output := OutputFormat(*outputFlag)
+	switch output {
+	case OutputText, OutputJSON, OutputYAML:
+	default:
+		log.Fatalf("Invalid -output %q: must be one of text, json, yaml", *outputFlag)
+	}
+
 	// Setup kubernetes client
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -58,28 +100,85 @@ func main() {
 		log.Fatalf("Failed to create kubernetes client: %v", err)
 	}
 
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("Failed to create dynamic client: %v", err)
+	}
+
 	ctx := context.Background()
 
-	// Handle Istio workload restarts first
-	log.Println("Restarting Istio workloads...")
-	for _, workload := range istioWorkloads {
+	includeNamespaces := parseCSV(*includeNamespaceFlag)
+	excludeNamespaces := parseCSV(*excludeNamespaceFlag)
+	excludeWorkloads := parseCSV(*excludeWorkloadFlag)
+
+	coordinator := NewRolloutCoordinator(*maxConcurrentRestarts, *perNamespaceConcurrency, *restartInterval, excludeWorkloads, strategy)
+
+	if *preflight || *preflightOnly {
+		preflightNamespaces, err := getNamespaces(ctx, clientset, *namespace, *allNamespaces, includeNamespaces, excludeNamespaces)
+		if err != nil {
+			log.Fatalf("Failed to get namespaces for preflight: %v", err)
+		}
+
+		report, err := RunPreflight(ctx, clientset, preflightNamespaces)
+		if err != nil {
+			log.Fatalf("Failed to run preflight checks: %v", err)
+		}
+		report.Log()
+
+		if report.Failed() {
+			log.Fatalf("Preflight checks failed; aborting before restarting any workloads")
+		}
+		if *preflightOnly {
+			return
+		}
+	}
+
+	if *dryRun {
+		namespaces, err := getNamespaces(ctx, clientset, *namespace, *allNamespaces, includeNamespaces, excludeNamespaces)
+		if err != nil {
+			log.Fatalf("Failed to get namespaces: %v", err)
+		}
+		if err := runDryRun(ctx, clientset, dyn, namespaces, mode, strategy, excludeWorkloads, *labelSelector, *fieldSelector, output); err != nil {
+			log.Fatalf("Failed to build restart plan: %v", err)
+		}
+		return
+	}
+
+	// Handle Istio workload restarts first, whether running once or continuously: istiod, then
+	// the gateway, then CNI, paced by -restart-interval so ingress traffic isn't disrupted mid-upgrade.
+	log.Printf("Restarting Istio workloads (mode=%s)...", mode)
+	for i, workload := range workloadsForMode(mode) {
+		if i > 0 {
+			time.Sleep(*restartInterval)
+		}
 		if err := restartIstioWorkload(ctx, clientset, workload); err != nil {
 			log.Printf("Failed to restart %s %s: %v", workload.Type, workload.Name, err)
 		}
 	}
+	time.Sleep(*restartInterval)
 
-	// Get list of namespaces to process
-	namespaces, err := getNamespaces(ctx, clientset, *namespace, *allNamespaces)
+	if *once {
+		runBatch(ctx, clientset, dyn, *namespace, *allNamespaces, mode, strategy, coordinator, includeNamespaces, excludeNamespaces, *labelSelector, *fieldSelector)
+		return
+	}
+
+	runController(clientset, dyn, *namespace, *allNamespaces, mode, strategy, *workers, coordinator, includeNamespaces, excludeNamespaces, *labelSelector, *fieldSelector)
+}
+
+// runBatch lists every namespace and every pod once, the original one-shot behavior, preserved
+// behind -once for environments that run this as a Job rather than a long-lived Deployment.
+func runBatch(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, namespace string, allNamespaces bool, mode DataPlaneMode, strategy RestartStrategy, coordinator *RolloutCoordinator, includeNamespaces, excludeNamespaces []string, labelSelector, fieldSelector string) {
+	namespaces, err := getNamespaces(ctx, clientset, namespace, allNamespaces, includeNamespaces, excludeNamespaces)
 	if err != nil {
 		log.Fatalf("Failed to get namespaces: %v", err)
 	}
 
 	log.Printf("Processing %d namespaces for Istio sidecar restarts", len(namespaces))
 
-	// Process pods in each namespace
 	processedPods := 0
+	seen := make(map[string]bool)
 	for _, ns := range namespaces {
-		count, err := processPodsInNamespace(ctx, clientset, ns)
+		count, err := processPodsInNamespace(ctx, clientset, dyn, ns, mode, strategy, coordinator, labelSelector, fieldSelector, seen)
 		if err != nil {
 			log.Printf("Error processing pods in namespace %s: %v", ns, err)
 			continue
@@ -90,6 +189,45 @@ func main() {
 	log.Printf("Successfully processed %d pods with Istio sidecars", processedPods)
 }
 
+// runController watches pods continuously via shared informers and restarts owning workloads
+// through a rate-limited work queue, so the tool reacts to newly-injected pods as a long-running
+// Deployment instead of requiring a re-run.
+func runController(clientset *kubernetes.Clientset, dyn dynamic.Interface, namespace string, allNamespaces bool, mode DataPlaneMode, strategy RestartStrategy, workers int, coordinator *RolloutCoordinator, includeNamespaces, excludeNamespaces []string, labelSelector, fieldSelector string) {
+	// -label-selector/-field-selector only ever describe which pods to watch, so they must only
+	// tweak the Pods informer's list options. Applying them factory-wide would also filter the
+	// Deployment/DaemonSet/StatefulSet informers by a pod selector, silently starving them.
+	podsTweak := informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = labelSelector
+		options.FieldSelector = fieldSelector
+	})
+
+	var podFactory, workloadFactory informers.SharedInformerFactory
+	if allNamespaces {
+		podFactory = informers.NewSharedInformerFactoryWithOptions(clientset, 30*time.Minute, podsTweak)
+		workloadFactory = informers.NewSharedInformerFactory(clientset, 30*time.Minute)
+	} else {
+		podFactory = informers.NewSharedInformerFactoryWithOptions(clientset, 30*time.Minute, informers.WithNamespace(namespace), podsTweak)
+		workloadFactory = informers.NewSharedInformerFactoryWithOptions(clientset, 30*time.Minute, informers.WithNamespace(namespace))
+	}
+
+	controller := NewController(clientset, dyn, podFactory, workloadFactory, mode, strategy, coordinator, includeNamespaces, excludeNamespaces)
+
+	stopCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		close(stopCh)
+	}()
+
+	podFactory.Start(stopCh)
+	workloadFactory.Start(stopCh)
+	podFactory.WaitForCacheSync(stopCh)
+	workloadFactory.WaitForCacheSync(stopCh)
+
+	controller.Run(workers, stopCh)
+}
+
 // restartIstioWorkload handles the restart of a specific Istio workload
 func restartIstioWorkload(ctx context.Context, clientset *kubernetes.Clientset, workload IstioWorkload) error {
 	switch workload.Type {
@@ -135,8 +273,9 @@ func addRestartAnnotation(annotations map[string]string) map[string]string {
 	return annotations
 }
 
-// getNamespaces returns the list of namespaces to process
-func getNamespaces(ctx context.Context, clientset *kubernetes.Clientset, namespace string, allNamespaces bool) ([]string, error) {
+// getNamespaces returns the list of namespaces to process, applying the -include-namespace and
+// -exclude-namespace filters on top of either the single -namespace or the full cluster list.
+func getNamespaces(ctx context.Context, clientset *kubernetes.Clientset, namespace string, allNamespaces bool, includeNamespaces, excludeNamespaces []string) ([]string, error) {
 	if allNamespaces {
 		namespaceList, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
 		if err != nil {
@@ -149,39 +288,66 @@ func getNamespaces(ctx context.Context, clientset *kubernetes.Clientset, namespa
 			if ns.Name == "kube-system" || ns.Name == "kube-public" || ns.Name == "kube-node-lease" {
 				continue
 			}
+			if !namespaceIncluded(ns.Name, includeNamespaces, excludeNamespaces) {
+				continue
+			}
 			namespaces = append(namespaces, ns.Name)
 		}
 		return namespaces, nil
 	}
+
+	if !namespaceIncluded(namespace, includeNamespaces, excludeNamespaces) {
+		return nil, nil
+	}
 	return []string{namespace}, nil
 }
 
 // hasIstioSidecar checks if a pod has Istio sidecar injection
 func hasIstioSidecar(pod *corev1.Pod) bool {
-	// Check for istio-init init container
-	for _, container := range pod.Spec.InitContainers {
+	return hasIstioSidecarInitContainers(pod.Spec.InitContainers)
+}
+
+// hasIstioSidecarInitContainers checks a pod's (or pod template's) init containers for the
+// istio-init/istio-validation container the sidecar injector stamps in.
+func hasIstioSidecarInitContainers(initContainers []corev1.Container) bool {
+	for _, container := range initContainers {
 		if container.Name == "istio-init" || container.Name == "istio-validation" {
 			return true
 		}
 	}
-
 	return false
 }
 
-// processPodsInNamespace processes all pods in a given namespace and returns count of processed pods
-func processPodsInNamespace(ctx context.Context, clientset *kubernetes.Clientset, namespace string) (int, error) {
-	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+// processPodsInNamespace processes all pods in a given namespace and returns count of processed
+// pods. seen dedupes ztunnel-per-node and waypoint-per-Deployment ambient restarts, the same
+// "namespace/kind/name" keys planDryRun uses, and is shared across every namespace runBatch
+// processes so a node hosting ambient pods from more than one namespace isn't rolled twice.
+func processPodsInNamespace(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, namespace string, mode DataPlaneMode, strategy RestartStrategy, coordinator *RolloutCoordinator, labelSelector, fieldSelector string, seen map[string]bool) (int, error) {
+	namespaceIsAmbient := false
+	if ambientEligible(mode) {
+		ns, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if err != nil {
+			return 0, fmt.Errorf("failed to get namespace %s: %v", namespace, err)
+		}
+		namespaceIsAmbient = isAmbientNamespace(ns)
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labelSelector,
+		FieldSelector: fieldSelector,
+	})
 	if err != nil {
 		return 0, fmt.Errorf("failed to list pods in namespace %s: %v", namespace, err)
 	}
 
 	processedCount := 0
 	for _, pod := range pods.Items {
-		if !hasIstioSidecar(&pod) {
-			continue // Skip pods without Istio sidecar
+		ambient := ambientEligible(mode) && isAmbientPod(&pod, namespaceIsAmbient)
+		if !ambient && (!sidecarEligible(mode) || !hasIstioSidecar(&pod)) {
+			continue // Skip pods this mode doesn't restart
 		}
 
-		if err := processPod(ctx, clientset, &pod); err != nil {
+		if err := processPod(ctx, clientset, dyn, &pod, ambient, strategy, coordinator, seen); err != nil {
 			log.Printf("Error processing pod %s/%s: %v", namespace, pod.Name, err)
 			continue
 		}
@@ -195,18 +361,26 @@ func processPodsInNamespace(ctx context.Context, clientset *kubernetes.Clientset
 	return processedCount, nil
 }
 
-func processPod(ctx context.Context, clientset *kubernetes.Clientset, pod *corev1.Pod) error {
+func processPod(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, pod *corev1.Pod, ambient bool, strategy RestartStrategy, coordinator *RolloutCoordinator, seen map[string]bool) error {
+	if ambient {
+		log.Printf("Processing ambient pod %s/%s", pod.Namespace, pod.Name)
+		return processAmbientPod(ctx, clientset, dyn, coordinator, pod, seen)
+	}
+
 	log.Printf("Processing pod %s/%s with Istio sidecar", pod.Namespace, pod.Name)
 
 	if len(pod.OwnerReferences) == 0 {
-		log.Printf("Skipping pod %s/%s: no owner references", pod.Namespace, pod.Name)
-		return nil
+		if strategy == StrategyAnnotate {
+			log.Printf("Skipping pod %s/%s: no owner references and -strategy=annotate", pod.Namespace, pod.Name)
+			return nil
+		}
+		return deletePodForRestart(ctx, clientset, pod.Namespace, pod.Name)
 	}
 
-	return traverseOwners(ctx, clientset, pod.Namespace, pod.OwnerReferences[0])
+	return traverseOwners(ctx, clientset, dyn, pod.Namespace, pod.Name, pod.OwnerReferences[0], strategy, coordinator)
 }
 
-func traverseOwners(ctx context.Context, clientset *kubernetes.Clientset, namespace string, ownerRef metav1.OwnerReference) error {
+func traverseOwners(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, namespace, podName string, ownerRef metav1.OwnerReference, strategy RestartStrategy, coordinator *RolloutCoordinator) error {
 	switch ownerRef.Kind {
 	case "ReplicaSet":
 		// Get the ReplicaSet
@@ -217,18 +391,19 @@ func traverseOwners(ctx context.Context, clientset *kubernetes.Clientset, namesp
 
 		// Check if ReplicaSet has an owner (Deployment)
 		if len(rs.OwnerReferences) > 0 {
-			return traverseOwners(ctx, clientset, namespace, rs.OwnerReferences[0])
+			return traverseOwners(ctx, clientset, dyn, namespace, podName, rs.OwnerReferences[0], strategy, coordinator)
 		}
 		log.Printf("ReplicaSet %s/%s has no owner references", namespace, ownerRef.Name)
 
-	case "Deployment":
-		return restartWorkload(ctx, clientset, namespace, ownerRef.Name, "Deployment")
+	case "Deployment", "DaemonSet", "StatefulSet", "ReplicationController", "DeploymentConfig":
+		return coordinator.restartWorkloadSafely(ctx, clientset, dyn, namespace, ownerRef.Name, ownerRef.Kind, podName)
 
-	case "DaemonSet":
-		return restartWorkload(ctx, clientset, namespace, ownerRef.Name, "DaemonSet")
-
-	case "StatefulSet":
-		return restartWorkload(ctx, clientset, namespace, ownerRef.Name, "StatefulSet")
+	case "Job":
+		if strategy == StrategyAnnotate {
+			log.Printf("Skipping Job-owned pod %s/%s: Jobs have no rolling-update path and -strategy=annotate", namespace, podName)
+			return nil
+		}
+		return restartJobOwnedPod(ctx, clientset, namespace, podName, ownerRef.Name)
 
 	default:
 		log.Printf("Unsupported owner kind: %s for %s/%s", ownerRef.Kind, namespace, ownerRef.Name)
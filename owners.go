@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RestartStrategy controls how a workload without a clean rolling-update path is restarted.
+type RestartStrategy string
+
+const (
+	StrategyAnnotate RestartStrategy = "annotate"
+	StrategyDelete   RestartStrategy = "delete"
+	StrategyAuto     RestartStrategy = "auto"
+)
+
+// deploymentConfigGVR identifies OpenShift's DeploymentConfig resource, which has no typed client
+// in client-go and so is reached through the dynamic client, the same way kiali's workloads.go does.
+var deploymentConfigGVR = schema.GroupVersionResource{
+	Group:    "apps.openshift.io",
+	Version:  "v1",
+	Resource: "deploymentconfigs",
+}
+
+// restartReplicationController rolls a bare ReplicationController the same way a Deployment is rolled.
+func restartReplicationController(ctx context.Context, clientset *kubernetes.Clientset, namespace, name string) error {
+	rc, err := clientset.CoreV1().ReplicationControllers(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get ReplicationController %s: %v", name, err)
+	}
+
+	rc.Spec.Template.Annotations = addRestartAnnotation(rc.Spec.Template.Annotations)
+	_, err = clientset.CoreV1().ReplicationControllers(namespace).Update(ctx, rc, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update ReplicationController %s: %v", name, err)
+	}
+
+	log.Printf("Successfully restarted ReplicationController %s in namespace %s", name, namespace)
+	return nil
+}
+
+// restartDeploymentConfig rolls an OpenShift DeploymentConfig by annotating its pod template,
+// mirroring how `oc rollout restart` triggers a new deployment.
+func restartDeploymentConfig(ctx context.Context, dyn dynamic.Interface, namespace, name string) error {
+	dc, err := dyn.Resource(deploymentConfigGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get DeploymentConfig %s: %v", name, err)
+	}
+
+	annotations, _, _ := unstructured.NestedStringMap(dc.Object, "spec", "template", "metadata", "annotations")
+	annotations = addRestartAnnotation(annotations)
+	if err := unstructured.SetNestedStringMap(dc.Object, annotations, "spec", "template", "metadata", "annotations"); err != nil {
+		return fmt.Errorf("failed to set restart annotation on DeploymentConfig %s: %v", name, err)
+	}
+
+	if _, err := dyn.Resource(deploymentConfigGVR).Namespace(namespace).Update(ctx, dc, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update DeploymentConfig %s: %v", name, err)
+	}
+
+	log.Printf("Successfully restarted DeploymentConfig %s in namespace %s", name, namespace)
+	return nil
+}
+
+// deletePodForRestart deletes a pod so it is re-admitted with a fresh Istio sidecar. This is the
+// only option for workloads with no rolling-update path: bare pods, and Jobs, whose pod template
+// cannot be patched in place to trigger a new rollout.
+func deletePodForRestart(ctx context.Context, clientset *kubernetes.Clientset, namespace, name string) error {
+	if err := clientset.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("failed to delete pod %s: %v", name, err)
+	}
+	log.Printf("Deleted pod %s/%s to force re-admission with a fresh sidecar", namespace, name)
+	return nil
+}
+
+// deleteWorkloadPods implements the -strategy=delete path for workload kinds that do have a clean
+// rolling-update path (Deployments, DaemonSets, StatefulSets, ReplicationControllers,
+// DeploymentConfigs): rather than patching the pod template, it deletes podName if the caller
+// already knows which pod triggered the restart, or every pod matching podLabels otherwise, as
+// when the continuous controller dequeues a workload key with no specific pod attached.
+func deleteWorkloadPods(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podLabels labels.Set, podName string) error {
+	if podName != "" {
+		return deletePodForRestart(ctx, clientset, namespace, podName)
+	}
+	if podLabels == nil {
+		return fmt.Errorf("no pod selector available to delete pods in namespace %s", namespace)
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(podLabels).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods in namespace %s: %v", namespace, err)
+	}
+	for _, pod := range pods.Items {
+		if err := deletePodForRestart(ctx, clientset, namespace, pod.Name); err != nil {
+			log.Printf("Failed to delete pod %s/%s: %v", namespace, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// logCronJobOwner notes when a Job is itself owned by a CronJob: that is informational only, since
+// the CronJob's next scheduled run already gets a fresh sidecar and needs no action here.
+func logCronJobOwner(namespace string, job *batchv1.Job) {
+	if len(job.OwnerReferences) > 0 && job.OwnerReferences[0].Kind == "CronJob" {
+		log.Printf("Job %s/%s is owned by CronJob %s; its next scheduled run will get a fresh sidecar", namespace, job.Name, job.OwnerReferences[0].Name)
+	}
+}
+
+// restartJobOwnedPod handles a single pod owned by a Job. Jobs have no rolling-update path, so the
+// pod itself is deleted; a still-running Job's controller spawns a replacement, picking up a fresh
+// sidecar, while a completed Job simply loses its (already-finished) pod.
+func restartJobOwnedPod(ctx context.Context, clientset *kubernetes.Clientset, namespace, podName, jobName string) error {
+	job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, jobName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get Job %s: %v", jobName, err)
+	}
+	logCronJobOwner(namespace, job)
+
+	return deletePodForRestart(ctx, clientset, namespace, podName)
+}
+
+// restartJob handles a Job restart when the triggering pod is no longer known, as is the case when
+// a workload key is dequeued by the controller rather than walked directly from a pod event: every
+// pod currently owned by the Job is deleted instead.
+func restartJob(ctx context.Context, clientset *kubernetes.Clientset, namespace, jobName string) error {
+	job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, jobName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get Job %s: %v", jobName, err)
+	}
+	logCronJobOwner(namespace, job)
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list pods in namespace %s: %v", namespace, err)
+	}
+
+	for _, pod := range pods.Items {
+		if !podOwnedBy(pod.OwnerReferences, "Job", jobName) {
+			continue
+		}
+		if err := deletePodForRestart(ctx, clientset, namespace, pod.Name); err != nil {
+			log.Printf("Failed to delete pod %s/%s owned by Job %s: %v", namespace, pod.Name, jobName, err)
+		}
+	}
+	return nil
+}
+
+// podOwnedBy reports whether ownerRefs contains a reference to the given kind and name.
+func podOwnedBy(ownerRefs []metav1.OwnerReference, kind, name string) bool {
+	for _, ref := range ownerRefs {
+		if ref.Kind == kind && ref.Name == name {
+			return true
+		}
+	}
+	return false
+}
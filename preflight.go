@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apimachineryversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+)
+
+// minKubernetesVersion is the oldest Kubernetes minor version Istio's control plane supports,
+// mirroring the floor istioctl x precheck enforces.
+const minKubernetesVersion = "1.27.0"
+
+// PreflightSeverity distinguishes checks that must pass before continuing from those that only warn.
+type PreflightSeverity string
+
+const (
+	SeverityHard PreflightSeverity = "hard"
+	SeverityWarn PreflightSeverity = "warn"
+)
+
+// PreflightCheck records the outcome of a single precheck.
+type PreflightCheck struct {
+	Name     string
+	Severity PreflightSeverity
+	Passed   bool
+	Message  string
+}
+
+// PreflightReport is the structured result of running all preflight checks.
+type PreflightReport struct {
+	Checks []PreflightCheck
+}
+
+// Failed reports whether any hard check in the report did not pass.
+func (r *PreflightReport) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Severity == SeverityHard && !c.Passed {
+			return true
+		}
+	}
+	return false
+}
+
+// Log prints each preflight check, in order, to the standard logger.
+func (r *PreflightReport) Log() {
+	for _, c := range r.Checks {
+		status := "PASS"
+		if !c.Passed {
+			status = "FAIL"
+			if c.Severity == SeverityWarn {
+				status = "WARN"
+			}
+		}
+		log.Printf("[preflight] %-6s %-30s %s", status, c.Name, c.Message)
+	}
+}
+
+// RunPreflight mirrors the checks istioctl x precheck runs before touching any workloads: server
+// version compatibility, RBAC for the verbs this tool needs, pods whose sidecar is already stale
+// relative to istiod, and namespaces whose injection label doesn't match their pod population.
+func RunPreflight(ctx context.Context, clientset *kubernetes.Clientset, namespaces []string) (*PreflightReport, error) {
+	report := &PreflightReport{}
+
+	report.Checks = append(report.Checks, checkServerVersion(clientset))
+	report.Checks = append(report.Checks, checkRBAC(ctx, clientset, namespaces)...)
+	report.Checks = append(report.Checks, checkStaleSidecars(ctx, clientset, namespaces)...)
+	report.Checks = append(report.Checks, checkInjectionLabelMismatch(ctx, clientset, namespaces)...)
+
+	return report, nil
+}
+
+func checkServerVersion(clientset *kubernetes.Clientset) PreflightCheck {
+	info, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return PreflightCheck{Name: "server-version", Severity: SeverityHard, Message: fmt.Sprintf("failed to get server version: %v", err)}
+	}
+
+	serverVersion, err := apimachineryversion.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return PreflightCheck{Name: "server-version", Severity: SeverityHard, Message: fmt.Sprintf("failed to parse server version %q: %v", info.GitVersion, err)}
+	}
+	minVersion, err := apimachineryversion.ParseGeneric(minKubernetesVersion)
+	if err != nil {
+		return PreflightCheck{Name: "server-version", Severity: SeverityHard, Message: fmt.Sprintf("failed to parse minimum version %q: %v", minKubernetesVersion, err)}
+	}
+
+	if serverVersion.LessThan(minVersion) {
+		return PreflightCheck{Name: "server-version", Severity: SeverityHard, Message: fmt.Sprintf("server version %s is below the minimum supported %s", info.GitVersion, minKubernetesVersion)}
+	}
+	return PreflightCheck{Name: "server-version", Severity: SeverityHard, Passed: true, Message: fmt.Sprintf("server version %s meets the minimum %s", info.GitVersion, minKubernetesVersion)}
+}
+
+// checkRBAC confirms the tool's ServiceAccount can get/update Deployments, DaemonSets, and
+// StatefulSets and list pods in each target namespace, via SelfSubjectAccessReview. Access to the
+// other kinds it restarts (ReplicationControllers, DeploymentConfigs, Jobs) and to deleting pods is
+// not checked.
+func checkRBAC(ctx context.Context, clientset *kubernetes.Clientset, namespaces []string) []PreflightCheck {
+	var checks []PreflightCheck
+
+	resources := []struct {
+		group    string
+		resource string
+		verb     string
+	}{
+		{"apps", "deployments", "get"},
+		{"apps", "deployments", "update"},
+		{"apps", "daemonsets", "get"},
+		{"apps", "daemonsets", "update"},
+		{"apps", "statefulsets", "get"},
+		{"apps", "statefulsets", "update"},
+	}
+
+	for _, ns := range namespaces {
+		for _, r := range resources {
+			name := fmt.Sprintf("rbac-%s-%s-%s-%s", ns, r.resource, r.verb, r.group)
+			allowed, err := canI(ctx, clientset, ns, r.group, r.resource, r.verb)
+			if err != nil {
+				checks = append(checks, PreflightCheck{Name: name, Severity: SeverityHard, Message: fmt.Sprintf("failed to check access: %v", err)})
+				continue
+			}
+			checks = append(checks, PreflightCheck{Name: name, Severity: SeverityHard, Passed: allowed, Message: fmt.Sprintf("%s %s.%s in %s", r.verb, r.resource, r.group, ns)})
+		}
+
+		name := fmt.Sprintf("rbac-%s-pods-list", ns)
+		allowed, err := canI(ctx, clientset, ns, "", "pods", "list")
+		if err != nil {
+			checks = append(checks, PreflightCheck{Name: name, Severity: SeverityHard, Message: fmt.Sprintf("failed to check access: %v", err)})
+			continue
+		}
+		checks = append(checks, PreflightCheck{Name: name, Severity: SeverityHard, Passed: allowed, Message: fmt.Sprintf("list pods in %s", ns)})
+	}
+
+	return checks
+}
+
+func canI(ctx context.Context, clientset *kubernetes.Clientset, namespace, group, resource, verb string) (bool, error) {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Group:     group,
+				Resource:  resource,
+				Verb:      verb,
+			},
+		},
+	}
+
+	result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return result.Status.Allowed, nil
+}
+
+// checkStaleSidecars flags pods whose injected Envoy sidecar image tag differs from the image tag
+// istiod itself is running, which indicates a rollout is already needed independent of this tool.
+func checkStaleSidecars(ctx context.Context, clientset *kubernetes.Clientset, namespaces []string) []PreflightCheck {
+	istiodVersion, err := istiodImageTag(ctx, clientset)
+	if err != nil {
+		return []PreflightCheck{{Name: "stale-sidecars", Severity: SeverityWarn, Message: fmt.Sprintf("could not determine istiod version: %v", err)}}
+	}
+
+	var checks []PreflightCheck
+	for _, ns := range namespaces {
+		pods, err := clientset.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			checks = append(checks, PreflightCheck{Name: fmt.Sprintf("stale-sidecars-%s", ns), Severity: SeverityWarn, Message: fmt.Sprintf("failed to list pods: %v", err)})
+			continue
+		}
+
+		stale := 0
+		for _, pod := range pods.Items {
+			if tag, ok := proxyImageTag(&pod); ok && tag != istiodVersion {
+				stale++
+			}
+		}
+		checks = append(checks, PreflightCheck{
+			Name:     fmt.Sprintf("stale-sidecars-%s", ns),
+			Severity: SeverityWarn,
+			Passed:   stale == 0,
+			Message:  fmt.Sprintf("%d pod(s) in %s carry a sidecar at a different version than istiod (%s)", stale, ns, istiodVersion),
+		})
+	}
+	return checks
+}
+
+func istiodImageTag(ctx context.Context, clientset *kubernetes.Clientset) (string, error) {
+	dep, err := clientset.AppsV1().Deployments(istioNamespace).Get(ctx, "istiod", metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get istiod deployment: %v", err)
+	}
+	for _, c := range dep.Spec.Template.Spec.Containers {
+		if c.Name == "discovery" {
+			return imageTag(c.Image), nil
+		}
+	}
+	return "", fmt.Errorf("istiod deployment has no discovery container")
+}
+
+func proxyImageTag(pod *corev1.Pod) (string, bool) {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == "istio-proxy" {
+			return imageTag(c.Image), true
+		}
+	}
+	return "", false
+}
+
+func imageTag(image string) string {
+	if i := strings.LastIndex(image, ":"); i != -1 {
+		return image[i+1:]
+	}
+	return image
+}
+
+// checkInjectionLabelMismatch warns about namespaces that have istio-injection=enabled but no
+// injected pods, or injected pods in a namespace without the label, either of which usually means
+// injection is misconfigured.
+func checkInjectionLabelMismatch(ctx context.Context, clientset *kubernetes.Clientset, namespaces []string) []PreflightCheck {
+	var checks []PreflightCheck
+
+	for _, ns := range namespaces {
+		name := fmt.Sprintf("injection-label-%s", ns)
+
+		nsObj, err := clientset.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+		if err != nil {
+			checks = append(checks, PreflightCheck{Name: name, Severity: SeverityWarn, Message: fmt.Sprintf("failed to get namespace: %v", err)})
+			continue
+		}
+		injectionEnabled := nsObj.Labels["istio-injection"] == "enabled"
+
+		pods, err := clientset.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			checks = append(checks, PreflightCheck{Name: name, Severity: SeverityWarn, Message: fmt.Sprintf("failed to list pods: %v", err)})
+			continue
+		}
+
+		hasInjectedPods := false
+		for _, pod := range pods.Items {
+			if hasIstioSidecar(&pod) {
+				hasInjectedPods = true
+				break
+			}
+		}
+
+		switch {
+		case injectionEnabled && !hasInjectedPods:
+			checks = append(checks, PreflightCheck{Name: name, Severity: SeverityWarn, Message: fmt.Sprintf("namespace %s has istio-injection=enabled but no injected pods", ns)})
+		case !injectionEnabled && hasInjectedPods:
+			checks = append(checks, PreflightCheck{Name: name, Severity: SeverityWarn, Message: fmt.Sprintf("namespace %s has injected pods but no istio-injection=enabled label", ns)})
+		default:
+			checks = append(checks, PreflightCheck{Name: name, Severity: SeverityWarn, Passed: true, Message: fmt.Sprintf("namespace %s injection label matches its pod population", ns)})
+		}
+	}
+
+	return checks
+}
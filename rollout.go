@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	pdbPollInterval     = 5 * time.Second
+	pdbWaitTimeout      = 5 * time.Minute
+	rolloutPollInterval = 5 * time.Second
+	rolloutWaitTimeout  = 10 * time.Minute
+
+	defaultMaxUnavailable = "25%"
+)
+
+// RolloutCoordinator throttles how many workloads are restarted at once, both cluster-wide and
+// per namespace, using buffered channels as counting semaphores so a rollout can't run ahead of
+// what the cluster is configured to tolerate.
+type RolloutCoordinator struct {
+	mu               sync.Mutex
+	global           chan struct{}
+	perNamespace     map[string]chan struct{}
+	namespaceCap     int
+	restartInterval  time.Duration
+	excludeWorkloads []string
+	strategy         RestartStrategy
+}
+
+// NewRolloutCoordinator builds a coordinator enforcing maxConcurrent restarts cluster-wide and
+// perNamespace restarts within any single namespace. restartInterval is stored on the coordinator
+// but not applied by it; main paces the control-plane component restarts itself. excludeWorkloads lists "namespace/kind/name" or "kind/name"
+// entries that should never be restarted, regardless of what triggers them. strategy is the
+// -strategy the restarter was invoked with, applied uniformly to every restart this coordinator
+// performs so a workload is restarted the same way whether it was reached from a batch run or the
+// continuous controller.
+func NewRolloutCoordinator(maxConcurrent, perNamespace int, restartInterval time.Duration, excludeWorkloads []string, strategy RestartStrategy) *RolloutCoordinator {
+	return &RolloutCoordinator{
+		global:           make(chan struct{}, maxConcurrent),
+		perNamespace:     make(map[string]chan struct{}),
+		namespaceCap:     perNamespace,
+		restartInterval:  restartInterval,
+		excludeWorkloads: excludeWorkloads,
+		strategy:         strategy,
+	}
+}
+
+// acquire blocks until both a global and a per-namespace restart slot are free, returning a
+// release function the caller must invoke once the restart and its rollout wait are complete.
+func (r *RolloutCoordinator) acquire(namespace string) func() {
+	r.mu.Lock()
+	nsCh, ok := r.perNamespace[namespace]
+	if !ok {
+		nsCh = make(chan struct{}, r.namespaceCap)
+		r.perNamespace[namespace] = nsCh
+	}
+	r.mu.Unlock()
+
+	r.global <- struct{}{}
+	nsCh <- struct{}{}
+
+	return func() {
+		<-nsCh
+		<-r.global
+	}
+}
+
+// restartWorkloadSafely wraps the per-kind restart functions with PDB-aware throttling: it waits
+// for a free concurrency slot, waits for any matching PodDisruptionBudget to allow a disruption,
+// issues the restart, then waits for the rollout to finish before releasing its slot. podName is
+// the specific pod that triggered this restart if the caller already knows it (the batch path,
+// walking owners from a pod), or "" if it doesn't (the continuous controller, which dequeues a
+// workload key with no pod attached); this is only consulted under -strategy=delete.
+func (r *RolloutCoordinator) restartWorkloadSafely(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, namespace, name, kind, podName string) error {
+	if workloadExcluded(namespace, kind, name, r.excludeWorkloads) {
+		log.Printf("Skipping %s %s/%s: matched -exclude-workload", kind, namespace, name)
+		return nil
+	}
+	skipped, err := isWorkloadSkipped(ctx, clientset, dyn, namespace, name, kind)
+	if err != nil {
+		return err
+	}
+	if skipped {
+		log.Printf("Skipping %s %s/%s: %s=true annotation", kind, namespace, name, skipAnnotation)
+		return nil
+	}
+
+	release := r.acquire(namespace)
+	defer release()
+
+	podLabels, replicas, maxUnavailable, err := workloadRolloutInfo(ctx, clientset, dyn, namespace, name, kind)
+	if err != nil {
+		return err
+	}
+
+	if err := waitForDisruptionsAllowed(ctx, clientset, namespace, podLabels); err != nil {
+		return err
+	}
+
+	switch {
+	case r.strategy == StrategyDelete && isSafeRestartKind(kind):
+		if err := deleteWorkloadPods(ctx, clientset, namespace, podLabels, podName); err != nil {
+			return err
+		}
+	case kind == "Deployment", kind == "DaemonSet", kind == "StatefulSet":
+		if err := restartWorkload(ctx, clientset, namespace, name, kind); err != nil {
+			return err
+		}
+	case kind == "ReplicationController":
+		if err := restartReplicationController(ctx, clientset, namespace, name); err != nil {
+			return err
+		}
+	case kind == "DeploymentConfig":
+		if err := restartDeploymentConfig(ctx, dyn, namespace, name); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported workload kind for safe restart: %s", kind)
+	}
+
+	return waitForRolloutComplete(ctx, clientset, namespace, name, kind, replicas, maxUnavailable)
+}
+
+// restartZtunnelOnNodeSafely wraps restartZtunnelOnNode with the same exclude-workload, skip
+// annotation, concurrency, and PDB gating restartWorkloadSafely applies to every other workload,
+// treating the cluster-wide ztunnel DaemonSet as the workload being checked even though only the
+// single node's pod is actually restarted.
+func (r *RolloutCoordinator) restartZtunnelOnNodeSafely(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, nodeName string) error {
+	const ztunnelName = "ztunnel"
+
+	if workloadExcluded(istioNamespace, "DaemonSet", ztunnelName, r.excludeWorkloads) {
+		log.Printf("Skipping ztunnel on node %s: matched -exclude-workload", nodeName)
+		return nil
+	}
+	skipped, err := isWorkloadSkipped(ctx, clientset, dyn, istioNamespace, ztunnelName, "DaemonSet")
+	if err != nil {
+		return err
+	}
+	if skipped {
+		log.Printf("Skipping ztunnel on node %s: %s=true annotation", nodeName, skipAnnotation)
+		return nil
+	}
+
+	release := r.acquire(istioNamespace)
+	defer release()
+
+	podLabels, _, _, err := workloadRolloutInfo(ctx, clientset, dyn, istioNamespace, ztunnelName, "DaemonSet")
+	if err != nil {
+		return err
+	}
+	if err := waitForDisruptionsAllowed(ctx, clientset, istioNamespace, podLabels); err != nil {
+		return err
+	}
+
+	return restartZtunnelOnNode(ctx, clientset, nodeName)
+}
+
+// isSafeRestartKind reports whether kind is one of the workload kinds restartWorkloadSafely
+// supports.
+func isSafeRestartKind(kind string) bool {
+	switch kind {
+	case "Deployment", "DaemonSet", "StatefulSet", "ReplicationController", "DeploymentConfig":
+		return true
+	default:
+		return false
+	}
+}
+
+// workloadRolloutInfo returns the workload's pod template labels, desired replica count, and the
+// maxUnavailable tolerated during a rolling update for the named workload. The pod template labels
+// (rather than the workload's selector) are what a PodDisruptionBudget's selector is matched
+// against, since they reflect the concrete labels the workload's pods actually carry.
+func workloadRolloutInfo(ctx context.Context, clientset *kubernetes.Clientset, dyn dynamic.Interface, namespace, name, kind string) (labels.Set, int32, int32, error) {
+	switch kind {
+	case "Deployment":
+		dep, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to get Deployment %s: %v", name, err)
+		}
+		replicas := replicasOrDefault(dep.Spec.Replicas)
+		maxUnavailable := maxUnavailableFor(dep.Spec.Strategy.RollingUpdate, replicas)
+		return dep.Spec.Template.Labels, replicas, maxUnavailable, nil
+
+	case "DaemonSet":
+		ds, err := clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to get DaemonSet %s: %v", name, err)
+		}
+		replicas := ds.Status.DesiredNumberScheduled
+		var maxUnavailable int
+		if ds.Spec.UpdateStrategy.RollingUpdate != nil && ds.Spec.UpdateStrategy.RollingUpdate.MaxUnavailable != nil {
+			maxUnavailable, _ = intstr.GetScaledValueFromIntOrPercent(ds.Spec.UpdateStrategy.RollingUpdate.MaxUnavailable, int(replicas), true)
+		}
+		return ds.Spec.Template.Labels, replicas, int32(maxUnavailable), nil
+
+	case "StatefulSet":
+		ss, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to get StatefulSet %s: %v", name, err)
+		}
+		replicas := replicasOrDefault(ss.Spec.Replicas)
+		return ss.Spec.Template.Labels, replicas, 0, nil
+
+	case "ReplicationController":
+		rc, err := clientset.CoreV1().ReplicationControllers(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to get ReplicationController %s: %v", name, err)
+		}
+		// ReplicationControllers roll without a maxUnavailable budget in this tool: wait for full
+		// replica availability before moving on.
+		return rc.Spec.Template.Labels, replicasOrDefault(rc.Spec.Replicas), 0, nil
+
+	case "DeploymentConfig":
+		dc, err := dyn.Resource(deploymentConfigGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to get DeploymentConfig %s: %v", name, err)
+		}
+		podLabels, _, _ := unstructured.NestedStringMap(dc.Object, "spec", "template", "metadata", "labels")
+		replicas, _, _ := unstructured.NestedInt64(dc.Object, "spec", "replicas")
+		// DeploymentConfigs roll without a maxUnavailable budget in this tool, same as RCs above.
+		return labels.Set(podLabels), int32(replicas), 0, nil
+
+	default:
+		return nil, 0, 0, nil
+	}
+}
+
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+func maxUnavailableFor(ru *appsv1.RollingUpdateDeployment, replicas int32) int32 {
+	if ru == nil || ru.MaxUnavailable == nil {
+		defaultPct := intstr.FromString(defaultMaxUnavailable)
+		v, _ := intstr.GetScaledValueFromIntOrPercent(&defaultPct, int(replicas), true)
+		return int32(v)
+	}
+	v, _ := intstr.GetScaledValueFromIntOrPercent(ru.MaxUnavailable, int(replicas), true)
+	return int32(v)
+}
+
+// waitForDisruptionsAllowed polls any PodDisruptionBudget whose selector matches the workload's
+// pod template labels until status.disruptionsAllowed > 0, or until pdbWaitTimeout elapses.
+func waitForDisruptionsAllowed(ctx context.Context, clientset *kubernetes.Clientset, namespace string, podLabels labels.Set) error {
+	if podLabels == nil {
+		return nil
+	}
+
+	deadline := time.Now().Add(pdbWaitTimeout)
+	for {
+		pdbs, err := clientset.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list PodDisruptionBudgets in namespace %s: %v", namespace, err)
+		}
+
+		blocked := blockingPDB(pdbs.Items, podLabels)
+		if blocked == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for PodDisruptionBudget %s/%s to allow a disruption", namespace, blocked.Name)
+		}
+
+		log.Printf("Waiting for PodDisruptionBudget %s/%s to allow a disruption (currently %d allowed)", namespace, blocked.Name, blocked.Status.DisruptionsAllowed)
+		time.Sleep(pdbPollInterval)
+	}
+}
+
+// blockingPDB returns the first PDB whose selector actually matches podLabels and that currently
+// allows no disruptions, or nil if none of the namespace's PDBs block this workload. A nil or
+// unparseable selector never matches, but an empty (non-nil) selector matches every pod in the
+// namespace, same as Kubernetes' own PDB admission check, so it is not skipped here.
+func blockingPDB(pdbs []policyv1.PodDisruptionBudget, podLabels labels.Set) *policyv1.PodDisruptionBudget {
+	for i := range pdbs {
+		pdb := &pdbs[i]
+		pdbSelector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			continue
+		}
+		if !pdbSelector.Matches(podLabels) {
+			continue
+		}
+		if pdb.Status.DisruptionsAllowed <= 0 {
+			return pdb
+		}
+	}
+	return nil
+}
+
+// waitForRolloutComplete polls the workload until its rollout has finished: updatedReplicas has
+// caught up to the desired replica count, and availableReplicas is within the tolerated
+// maxUnavailable budget, or until rolloutWaitTimeout elapses.
+func waitForRolloutComplete(ctx context.Context, clientset *kubernetes.Clientset, namespace, name, kind string, replicas, maxUnavailable int32) error {
+	deadline := time.Now().Add(rolloutWaitTimeout)
+	for {
+		done, err := rolloutIsComplete(ctx, clientset, namespace, name, kind, replicas, maxUnavailable)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s %s/%s to finish rolling out", kind, namespace, name)
+		}
+		time.Sleep(rolloutPollInterval)
+	}
+}
+
+func rolloutIsComplete(ctx context.Context, clientset *kubernetes.Clientset, namespace, name, kind string, replicas, maxUnavailable int32) (bool, error) {
+	minAvailable := replicas - maxUnavailable
+
+	switch kind {
+	case "Deployment":
+		dep, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get Deployment %s: %v", name, err)
+		}
+		return dep.Status.UpdatedReplicas == replicas && dep.Status.AvailableReplicas >= minAvailable, nil
+
+	case "DaemonSet":
+		ds, err := clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get DaemonSet %s: %v", name, err)
+		}
+		return ds.Status.UpdatedNumberScheduled == ds.Status.DesiredNumberScheduled && ds.Status.NumberAvailable >= minAvailable, nil
+
+	case "StatefulSet":
+		ss, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get StatefulSet %s: %v", name, err)
+		}
+		return ss.Status.UpdatedReplicas == replicas && ss.Status.AvailableReplicas >= minAvailable, nil
+
+	case "ReplicationController":
+		rc, err := clientset.CoreV1().ReplicationControllers(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get ReplicationController %s: %v", name, err)
+		}
+		return rc.Status.AvailableReplicas >= minAvailable, nil
+
+	default:
+		// DeploymentConfigs are annotated and re-admitted by their controller without a tracked
+		// rollout status this tool can poll; treat as immediately done.
+		return true, nil
+	}
+}